@@ -15,6 +15,8 @@ import (
 	"github.com/asticode/go-astitools/sync"
 	"github.com/asticode/go-astitools/worker"
 	"github.com/asticode/goav/avcodec"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
 	"github.com/pkg/errors"
 )
 
@@ -23,6 +25,7 @@ var countPktDumper uint64
 // PktDumper represents an object capable of dumping packets
 type PktDumper struct {
 	*astiencoder.BaseNode
+	closeFn          func() error
 	count            uint32
 	data             map[string]interface{}
 	e                astiencoder.EmitEventFunc
@@ -32,6 +35,19 @@ type PktDumper struct {
 	statIncomingRate *astistat.IncrementStat
 	statWorkRatio    *astistat.DurationRatioStat
 	t                *template.Template
+	tracer           opentracing.Tracer
+}
+
+// SetTracer configures the OpenTracing tracer used to instrument packet processing. Passing nil (the default)
+// disables tracing.
+func (d *PktDumper) SetTracer(tr opentracing.Tracer) {
+	d.tracer = tr
+}
+
+// SetCloseFunc registers a func invoked once when the node stops, so that PktDumpFunc implementations keeping
+// internal state (e.g. open segments or an open muxer) get a chance to flush and finalize it
+func (d *PktDumper) SetCloseFunc(fn func() error) {
+	d.closeFn = fn
 }
 
 // PktDumpFunc represents a pkt dump func
@@ -90,6 +106,17 @@ func (d *PktDumper) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
 		// Handle context
 		go d.q.HandleCtx(d.Context())
 
+		// Make sure to close the dump func properly once the queue is fully drained, so that any state it
+		// still holds onto (e.g. an open segment or muxer) gets flushed and finalized
+		defer func() {
+			if d.closeFn == nil {
+				return
+			}
+			if err := d.closeFn(); err != nil {
+				d.e(astiencoder.EventError(errors.Wrap(err, "astilibav: closing pkt dump func failed")))
+			}
+		}()
+
 		// Make sure to stop the queue properly
 		defer d.q.Stop()
 
@@ -110,22 +137,55 @@ func (d *PktDumper) Start(ctx context.Context, t astiencoder.CreateTaskFunc) {
 			// Create data
 			d.data["count"] = c
 			d.data["pts"] = pkt.Pts()
+			d.data["dts"] = pkt.Dts()
+			d.data["duration"] = pkt.Duration()
+			d.data["pos"] = pkt.Pos()
+			d.data["size"] = pkt.Size()
 			d.data["stream_idx"] = pkt.StreamIndex()
+			d.data["keyframe"] = pkt.Flags()&avcodec.AV_PKT_FLAG_KEY > 0
+			d.data["corrupt"] = pkt.Flags()&avcodec.AV_PKT_FLAG_CORRUPT > 0
+			d.data["discard"] = pkt.Flags()&avcodec.AV_PKT_FLAG_DISCARD > 0
+			d.data["side_data"] = sideDataNames(pkt)
+
+			// Start span
+			var span opentracing.Span
+			if d.tracer != nil {
+				var opts []opentracing.StartSpanOption
+				if sc, ok := pktSpanContext(pkt); ok {
+					opts = append(opts, opentracing.ChildOf(sc))
+				}
+				span = d.tracer.StartSpan("astilibav.pkt_dumper", opts...)
+				span.SetTag("stream_idx", pkt.StreamIndex())
+				span.SetTag("pts", pkt.Pts())
+				defer span.Finish()
+				deletePktSpanContext(pkt)
+			}
 
 			// Execute template
 			buf := &bytes.Buffer{}
 			d.statWorkRatio.Add(true)
 			if err := d.t.Execute(buf, d.data); err != nil {
 				d.statWorkRatio.Done(true)
+				if span != nil {
+					ext.Error.Set(span, true)
+					span.SetTag("error.message", err.Error())
+				}
 				d.e(astiencoder.EventError(errors.Wrapf(err, "astilibav: executing template %s with data %+v failed", d.pattern, d.data)))
 				return
 			}
 			d.statWorkRatio.Done(true)
+			if span != nil {
+				span.SetTag("pattern", buf.String())
+			}
 
 			// Dump
 			d.statWorkRatio.Add(true)
 			if err := d.fn(pkt, buf.String()); err != nil {
 				d.statWorkRatio.Done(true)
+				if span != nil {
+					ext.Error.Set(span, true)
+					span.SetTag("error.message", err.Error())
+				}
 				d.e(astiencoder.EventError(errors.Wrapf(err, "astilibav: pkt dump func with pattern %s failed", buf)))
 				return
 			}
@@ -156,3 +216,41 @@ var PktDumpFile = func(pkt *avcodec.Packet, pattern string) (err error) {
 	}
 	return
 }
+
+// sideDataTypeNames associates an AVPacketSideDataType (an untyped int constant, like the AV_PKT_FLAG_* ones) to
+// its name
+var sideDataTypeNames = map[int]string{
+	avcodec.AV_PKT_DATA_PALETTE:                    "palette",
+	avcodec.AV_PKT_DATA_NEW_EXTRADATA:              "new_extradata",
+	avcodec.AV_PKT_DATA_PARAM_CHANGE:               "param_change",
+	avcodec.AV_PKT_DATA_H263_MB_INFO:               "h263_mb_info",
+	avcodec.AV_PKT_DATA_REPLAYGAIN:                 "replaygain",
+	avcodec.AV_PKT_DATA_DISPLAYMATRIX:              "displaymatrix",
+	avcodec.AV_PKT_DATA_STEREO3D:                   "stereo3d",
+	avcodec.AV_PKT_DATA_AUDIO_SERVICE_TYPE:         "audio_service_type",
+	avcodec.AV_PKT_DATA_SKIP_SAMPLES:               "skip_samples",
+	avcodec.AV_PKT_DATA_JP_DUALMONO:                "jp_dualmono",
+	avcodec.AV_PKT_DATA_STRINGS_METADATA:           "strings_metadata",
+	avcodec.AV_PKT_DATA_SUBTITLE_POSITION:          "subtitle_position",
+	avcodec.AV_PKT_DATA_MATROSKA_BLOCKADDITIONAL:   "matroska_blockadditional",
+	avcodec.AV_PKT_DATA_WEBVTT_IDENTIFIER:          "webvtt_identifier",
+	avcodec.AV_PKT_DATA_WEBVTT_SETTINGS:            "webvtt_settings",
+	avcodec.AV_PKT_DATA_METADATA_UPDATE:            "metadata_update",
+	avcodec.AV_PKT_DATA_MPEGTS_STREAM_ID:           "mpegts_stream_id",
+	avcodec.AV_PKT_DATA_MASTERING_DISPLAY_METADATA: "mastering_display_metadata",
+	avcodec.AV_PKT_DATA_SPHERICAL:                  "spherical",
+	avcodec.AV_PKT_DATA_CONTENT_LIGHT_LEVEL:        "content_light_level",
+	avcodec.AV_PKT_DATA_A53_CC:                     "a53_cc",
+}
+
+// sideDataNames returns the names of the AVPacketSideDataType entries attached to pkt
+func sideDataNames(pkt *avcodec.Packet) (ns []string) {
+	for _, sd := range pkt.SideData() {
+		if n, ok := sideDataTypeNames[sd.Type()]; ok {
+			ns = append(ns, n)
+		} else {
+			ns = append(ns, fmt.Sprintf("unknown(%d)", sd.Type()))
+		}
+	}
+	return
+}
@@ -0,0 +1,163 @@
+package astilibav
+
+import "C"
+import (
+	"os"
+	"time"
+	"unsafe"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/goav/avcodec"
+	"github.com/pkg/errors"
+)
+
+// EventNameSegmentClosed is the name of the event emitted once a segment has been closed by a segmented pkt dumper
+const EventNameSegmentClosed = "astilibav.segment.closed"
+
+// EventSegmentClosedPayload is the payload of an EventNameSegmentClosed event
+type EventSegmentClosedPayload struct {
+	Filename string
+	FirstPTS int64
+	LastPTS  int64
+	Size     int64
+}
+
+// SegmentedPktDumperOptions represents options for creating a segmented pkt dumper
+type SegmentedPktDumperOptions struct {
+	// Fsync forces a fsync() on the underlying file once a segment is closed
+	Fsync bool
+	// MaxBytes rotates the segment once it has that many bytes written to it. 0 disables the trigger.
+	MaxBytes int64
+	// MaxDuration rotates the segment once it has been open for that long. 0 disables the trigger.
+	MaxDuration time.Duration
+	// MaxPTSDelta rotates the segment once the delta between its first and current packet's PTS reaches that
+	// value, expressed in the stream's own PTS unit. 0 disables the trigger.
+	MaxPTSDelta int64
+	// OnKeyframeAfter, when set, postpones a pending rotation (triggered by one of the options above) until the
+	// next keyframe, so that every segment opened by a rotation starts on a keyframe boundary. The first segment of a
+	// stream is opened on whichever packet of that stream comes first, keyframe or not.
+	OnKeyframeAfter bool
+}
+
+type pktDumperSegment struct {
+	f        *os.File
+	firstPTS int64
+	lastPTS  int64
+	pending  bool
+	size     int64
+	start    time.Time
+}
+
+// PktDumpSegmented creates a PktDumpFunc that keeps one open file per stream index and rotates it according to o,
+// along with a close func that must be invoked once the owning node stops, to flush and close any segment still
+// open (otherwise the last segment of every recording would never be finalized nor emit EventNameSegmentClosed).
+// PktDumper still executes the template for every packet, but the resulting pattern is only used when a new segment
+// is actually opened, to name its file; it's ignored for every other packet.
+func PktDumpSegmented(o SegmentedPktDumperOptions, e astiencoder.EmitEventFunc) (fn PktDumpFunc, closeFn func() error) {
+	ss := make(map[int]*pktDumperSegment)
+	fn = func(pkt *avcodec.Packet, pattern string) (err error) {
+		// Retrieve segment
+		idx := pkt.StreamIndex()
+		s, ok := ss[idx]
+		keyframe := pkt.Flags()&avcodec.AV_PKT_FLAG_KEY > 0
+
+		// Check whether the current segment needs to rotate
+		if ok && pktDumperSegmentShouldRotate(s, pkt.Pts(), keyframe, o) {
+			if err = closePktDumperSegment(s, o, e); err != nil {
+				return
+			}
+			delete(ss, idx)
+			ok = false
+		}
+
+		// Open a new segment
+		if !ok {
+			if s, err = newPktDumperSegment(pattern, pkt); err != nil {
+				return
+			}
+			ss[idx] = s
+		}
+
+		// Write payload
+		var n int
+		if n, err = s.f.Write(C.GoBytes(unsafe.Pointer(pkt.Data()), (C.int)(pkt.Size()))); err != nil {
+			err = errors.Wrapf(err, "astilibav: writing to file %s failed", s.f.Name())
+			return
+		}
+		s.size += int64(n)
+		s.lastPTS = pkt.Pts()
+		return
+	}
+	closeFn = func() (err error) {
+		for idx, s := range ss {
+			if cErr := closePktDumperSegment(s, o, e); cErr != nil && err == nil {
+				err = cErr
+			}
+			delete(ss, idx)
+		}
+		return
+	}
+	return
+}
+
+// pktDumperSegmentShouldRotate decides, from s's current state and the incoming packet's pts/keyframe, whether s
+// should be rotated according to o. It mutates s.pending, since once a rotation trigger has fired it stays armed
+// until OnKeyframeAfter lets it through, rather than needing to re-fire on every subsequent packet.
+func pktDumperSegmentShouldRotate(s *pktDumperSegment, pts int64, keyframe bool, o SegmentedPktDumperOptions) bool {
+	if !s.pending {
+		if o.MaxBytes > 0 && s.size >= o.MaxBytes {
+			s.pending = true
+		} else if o.MaxDuration > 0 && time.Since(s.start) >= o.MaxDuration {
+			s.pending = true
+		} else if o.MaxPTSDelta > 0 && s.firstPTS != avcodec.AV_NOPTS_VALUE && pts != avcodec.AV_NOPTS_VALUE && pts-s.firstPTS >= o.MaxPTSDelta {
+			s.pending = true
+		}
+	}
+	return s.pending && (!o.OnKeyframeAfter || keyframe)
+}
+
+func newPktDumperSegment(pattern string, pkt *avcodec.Packet) (s *pktDumperSegment, err error) {
+	s = &pktDumperSegment{firstPTS: pkt.Pts(), lastPTS: pkt.Pts(), start: time.Now()}
+	if s.f, err = os.Create(pattern); err != nil {
+		err = errors.Wrapf(err, "astilibav: creating file %s failed", pattern)
+		return
+	}
+	return
+}
+
+func closePktDumperSegment(s *pktDumperSegment, o SegmentedPktDumperOptions, e astiencoder.EmitEventFunc) (err error) {
+	if o.Fsync {
+		if err = s.f.Sync(); err != nil {
+			err = errors.Wrapf(err, "astilibav: syncing file %s failed", s.f.Name())
+			return
+		}
+	}
+	name := s.f.Name()
+	if err = s.f.Close(); err != nil {
+		err = errors.Wrapf(err, "astilibav: closing file %s failed", name)
+		return
+	}
+	if e != nil {
+		e(astiencoder.Event{
+			Name: EventNameSegmentClosed,
+			Payload: EventSegmentClosedPayload{
+				Filename: name,
+				FirstPTS: s.firstPTS,
+				LastPTS:  s.lastPTS,
+				Size:     s.size,
+			},
+		})
+	}
+	return
+}
+
+// NewSegmentedPktDumper creates a new pkt dumper rotating its output across multiple files according to o
+func NewSegmentedPktDumper(pattern string, o SegmentedPktDumperOptions, data map[string]interface{}, e astiencoder.EmitEventFunc) (d *PktDumper, err error) {
+	fn, closeFn := PktDumpSegmented(o, e)
+	if d, err = NewPktDumper(pattern, fn, data, e); err != nil {
+		err = errors.Wrap(err, "astilibav: creating pkt dumper failed")
+		return
+	}
+	d.SetCloseFunc(closeFn)
+	return
+}
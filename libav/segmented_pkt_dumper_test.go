@@ -0,0 +1,86 @@
+package astilibav
+
+import (
+	"testing"
+	"time"
+
+	"github.com/asticode/goav/avcodec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPktDumperSegmentShouldRotate(t *testing.T) {
+	for _, v := range []struct {
+		name     string
+		o        SegmentedPktDumperOptions
+		s        *pktDumperSegment
+		pts      int64
+		keyframe bool
+		want     bool
+	}{
+		{
+			name: "no trigger configured never rotates",
+			o:    SegmentedPktDumperOptions{},
+			s:    &pktDumperSegment{start: time.Now()},
+		},
+		{
+			name: "max bytes reached rotates",
+			o:    SegmentedPktDumperOptions{MaxBytes: 10},
+			s:    &pktDumperSegment{start: time.Now(), size: 10},
+			want: true,
+		},
+		{
+			name: "max bytes not reached doesn't rotate",
+			o:    SegmentedPktDumperOptions{MaxBytes: 10},
+			s:    &pktDumperSegment{start: time.Now(), size: 9},
+		},
+		{
+			name: "max duration reached rotates",
+			o:    SegmentedPktDumperOptions{MaxDuration: time.Millisecond},
+			s:    &pktDumperSegment{start: time.Now().Add(-time.Second)},
+			want: true,
+		},
+		{
+			name: "max pts delta reached rotates",
+			o:    SegmentedPktDumperOptions{MaxPTSDelta: 90000},
+			s:    &pktDumperSegment{start: time.Now(), firstPTS: 0},
+			pts:  90000,
+			want: true,
+		},
+		{
+			name: "max pts delta ignores AV_NOPTS_VALUE on the segment's first pts",
+			o:    SegmentedPktDumperOptions{MaxPTSDelta: 90000},
+			s:    &pktDumperSegment{start: time.Now(), firstPTS: avcodec.AV_NOPTS_VALUE},
+			pts:  90000,
+		},
+		{
+			name: "max pts delta ignores AV_NOPTS_VALUE on the incoming packet",
+			o:    SegmentedPktDumperOptions{MaxPTSDelta: 90000},
+			s:    &pktDumperSegment{start: time.Now(), firstPTS: 0},
+			pts:  avcodec.AV_NOPTS_VALUE,
+		},
+		{
+			name:     "pending rotation waits for a keyframe when OnKeyframeAfter is set",
+			o:        SegmentedPktDumperOptions{MaxBytes: 10, OnKeyframeAfter: true},
+			s:        &pktDumperSegment{start: time.Now(), size: 10},
+			keyframe: false,
+		},
+		{
+			name:     "pending rotation fires once a keyframe arrives",
+			o:        SegmentedPktDumperOptions{MaxBytes: 10, OnKeyframeAfter: true},
+			s:        &pktDumperSegment{start: time.Now(), size: 10},
+			keyframe: true,
+			want:     true,
+		},
+		{
+			name:     "a rotation pending from a prior packet stays armed until a keyframe, regardless of current triggers",
+			o:        SegmentedPktDumperOptions{OnKeyframeAfter: true},
+			s:        &pktDumperSegment{start: time.Now(), pending: true},
+			keyframe: true,
+			want:     true,
+		},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			assert.Equal(t, v.want, pktDumperSegmentShouldRotate(v.s, v.pts, v.keyframe, v.o))
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package astilibav
+
+import (
+	"io"
+	"sync"
+	"unsafe"
+
+	"github.com/asticode/goav/avcodec"
+	opentracing "github.com/opentracing/opentracing-go"
+	zipkin "github.com/openzipkin-contrib/zipkin-go-opentracing"
+	"github.com/pkg/errors"
+	jaeger "github.com/uber/jaeger-client-go"
+	jaegercfg "github.com/uber/jaeger-client-go/config"
+)
+
+// TracingCollector represents a span collector backend
+type TracingCollector string
+
+// Tracing collectors
+const (
+	TracingCollectorJaeger TracingCollector = "jaeger"
+	TracingCollectorZipkin TracingCollector = "zipkin"
+)
+
+// TracingOptions mirrors the Tracing config struct shape used elsewhere: a collector type, its endpoint, the
+// service name spans should be reported under, and a sampler rate between 0 and 1.
+type TracingOptions struct {
+	Collector   TracingCollector
+	Endpoint    string
+	SamplerRate float64
+	ServiceName string
+}
+
+// NewTracer creates a new OpenTracing tracer based on o. The returned io.Closer must be closed on shutdown so that
+// buffered spans get flushed to the collector.
+func NewTracer(o TracingOptions) (tr opentracing.Tracer, c io.Closer, err error) {
+	switch o.Collector {
+	case TracingCollectorJaeger:
+		cfg := jaegercfg.Configuration{
+			ServiceName: o.ServiceName,
+			Sampler: &jaegercfg.SamplerConfig{
+				Param: o.SamplerRate,
+				Type:  jaeger.SamplerTypeProbabilistic,
+			},
+			Reporter: &jaegercfg.ReporterConfig{
+				LocalAgentHostPort: o.Endpoint,
+			},
+		}
+		if tr, c, err = cfg.NewTracer(); err != nil {
+			err = errors.Wrap(err, "astilibav: creating jaeger tracer failed")
+			return
+		}
+	case TracingCollectorZipkin:
+		var collector zipkin.Collector
+		if collector, err = zipkin.NewHTTPCollector(o.Endpoint); err != nil {
+			err = errors.Wrapf(err, "astilibav: creating zipkin collector for endpoint %s failed", o.Endpoint)
+			return
+		}
+		var recorder = zipkin.NewRecorder(collector, false, o.Endpoint, o.ServiceName)
+		if tr, err = zipkin.NewTracer(recorder, zipkin.WithSampler(zipkin.NewBoundarySampler(o.SamplerRate, 0))); err != nil {
+			err = errors.Wrap(err, "astilibav: creating zipkin tracer failed")
+			return
+		}
+		c = collector.(io.Closer)
+	default:
+		err = errors.Errorf("astilibav: unknown tracing collector %s", o.Collector)
+		return
+	}
+	return
+}
+
+// pktSpan associates a packet with the span context it's currently carrying as it flows through the pipeline. It
+// keeps a strong reference to pkt alongside the context: the map is keyed by pointer (since avcodec.Packet can't
+// be extended with an extra field) and that pointer value is only ever valid as a key for as long as something
+// keeps the packet alive. Holding pkt here guarantees that for the entry's lifetime, so the GC can't free it and
+// hand the same address to an unrelated, later packet while the entry is still around.
+type pktSpan struct {
+	pkt *avcodec.Packet
+	sc  opentracing.SpanContext
+}
+
+var (
+	pktSpansMu sync.Mutex
+	pktSpans   = make(map[uintptr]*pktSpan)
+)
+
+func pktSpanKey(pkt *avcodec.Packet) uintptr {
+	return uintptr(unsafe.Pointer(pkt))
+}
+
+// pktSpanContext returns the span context carried by pkt, if any
+func pktSpanContext(pkt *avcodec.Packet) (sc opentracing.SpanContext, ok bool) {
+	pktSpansMu.Lock()
+	defer pktSpansMu.Unlock()
+	var s *pktSpan
+	if s, ok = pktSpans[pktSpanKey(pkt)]; ok {
+		sc = s.sc
+	}
+	return
+}
+
+// SetPktSpanContext makes pkt carry sc, so that a downstream PktHandler picks it up (via pktSpanContext) as the
+// parent of its own span instead of opening a root span.
+//
+// This is the seam end-to-end packet tracing is meant to hang off: a decoder/filter/encoder node, once it has
+// its own span for handling pkt, calls SetPktSpanContext(pkt, span.Context()) right before invoking the next
+// node's HandlePkt. None of those producer nodes live in this checkout (it only contains PktDumper, a sink), so
+// nothing here calls SetPktSpanContext yet - PktDumper only consumes it, via pktSpanContext in Start(). Likewise,
+// astiencoder.BaseNode itself isn't part of this checkout, so it can't be the one gathering spans; this seam
+// lives at the astilibav level until/unless BaseNode grows native tracing support upstream.
+func SetPktSpanContext(pkt *avcodec.Packet, sc opentracing.SpanContext) {
+	pktSpansMu.Lock()
+	defer pktSpansMu.Unlock()
+	pktSpans[pktSpanKey(pkt)] = &pktSpan{pkt: pkt, sc: sc}
+}
+
+// deletePktSpanContext forgets about the span context carried by pkt, e.g. once it reaches a sink node
+func deletePktSpanContext(pkt *avcodec.Packet) {
+	pktSpansMu.Lock()
+	defer pktSpansMu.Unlock()
+	delete(pktSpans, pktSpanKey(pkt))
+}
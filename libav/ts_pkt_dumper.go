@@ -0,0 +1,158 @@
+package astilibav
+
+import "C"
+import (
+	"context"
+	"os"
+	"sort"
+	"unsafe"
+
+	"github.com/asticode/go-astiencoder"
+	"github.com/asticode/go-astits"
+	"github.com/asticode/goav/avcodec"
+	"github.com/pkg/errors"
+)
+
+// DefaultTSStreamTypes maps common AVCodecID values to their MPEG-TS stream type, used by PktDumpTS to resolve a
+// TSPktDumperStream's StreamType when only a CodecID is provided
+var DefaultTSStreamTypes = map[avcodec.CodecId]astits.StreamType{
+	avcodec.CODEC_ID_AAC:        astits.StreamTypeAACAudio,
+	avcodec.CODEC_ID_H264:       astits.StreamTypeH264Video,
+	avcodec.CODEC_ID_HEVC:       astits.StreamTypeH265Video,
+	avcodec.CODEC_ID_MP2:        astits.StreamTypeMPEG1Audio,
+	avcodec.CODEC_ID_MPEG2VIDEO: astits.StreamTypeMPEG2Video,
+}
+
+// TSPktDumperStream represents the MPEG-TS PID a given packet stream index should be muxed as. StreamType is used
+// as is when set, otherwise it's resolved from CodecID through DefaultTSStreamTypes
+type TSPktDumperStream struct {
+	CodecID    avcodec.CodecId
+	PID        uint16
+	StreamType astits.StreamType
+}
+
+// streamType resolves s's MPEG-TS stream type, falling back to DefaultTSStreamTypes when StreamType is unset
+func (s TSPktDumperStream) streamType() (st astits.StreamType, err error) {
+	if s.StreamType > 0 {
+		st = s.StreamType
+		return
+	}
+	var ok bool
+	if st, ok = DefaultTSStreamTypes[s.CodecID]; !ok {
+		err = errors.Errorf("astilibav: no known MPEG-TS stream type for codec id %d, set TSPktDumperStream.StreamType explicitly", s.CodecID)
+		return
+	}
+	return
+}
+
+// TSPktDumperOptions represents options for creating a TS pkt dumper
+type TSPktDumperOptions struct {
+	// PCRPID forces the PCR PID. If not set, the PID of the stream with the lowest StreamIndex is used
+	PCRPID uint16
+	// Streams maps a packet's StreamIndex() to the MPEG-TS PID/stream type it should be muxed as
+	Streams map[int]TSPktDumperStream
+}
+
+// PktDumpTS creates a PktDumpFunc muxing incoming packets into a single MPEG-TS file using go-astits, along with a
+// close func that must be invoked once the owning node stops, so that the muxed file gets finalized.
+// PktDumper still executes the template for every packet, but only the pattern received with the first packet is
+// used, to open the output file; patterns received with later packets are ignored.
+func PktDumpTS(o TSPktDumperOptions) (fn PktDumpFunc, closeFn func() error) {
+	var f *os.File
+	var m *astits.Muxer
+	pids := make(map[int]uint16)
+	fn = func(pkt *avcodec.Packet, pattern string) (err error) {
+		// Resolve output once per segment
+		if m == nil {
+			if f, err = os.Create(pattern); err != nil {
+				err = errors.Wrapf(err, "astilibav: creating file %s failed", pattern)
+				return
+			}
+			m = astits.NewMuxer(context.Background(), f)
+
+			// Sort stream indexes so that elementary stream registration and the PCR PID fallback below are
+			// deterministic across runs, since ranging over o.Streams directly isn't
+			idxs := make([]int, 0, len(o.Streams))
+			for idx := range o.Streams {
+				idxs = append(idxs, idx)
+			}
+			sort.Ints(idxs)
+
+			for _, idx := range idxs {
+				s := o.Streams[idx]
+				var st astits.StreamType
+				if st, err = s.streamType(); err != nil {
+					return
+				}
+				if err = m.AddElementaryStream(astits.PMTElementaryStream{
+					ElementaryPID: s.PID,
+					StreamType:    st,
+				}); err != nil {
+					err = errors.Wrapf(err, "astilibav: adding elementary stream %d failed", s.PID)
+					return
+				}
+				pids[idx] = s.PID
+			}
+			if o.PCRPID > 0 {
+				m.SetPCRPID(o.PCRPID)
+			} else if len(idxs) > 0 {
+				m.SetPCRPID(o.Streams[idxs[0]].PID)
+			}
+		}
+
+		// Retrieve PID for this packet's stream
+		pid, ok := pids[pkt.StreamIndex()]
+		if !ok {
+			err = errors.Errorf("astilibav: no TS stream registered for stream index %d", pkt.StreamIndex())
+			return
+		}
+
+		// Create PES header
+		h := &astits.PESHeader{OptionalHeader: &astits.PESOptionalHeader{
+			MarkerBits:      2,
+			PTSDTSIndicator: astits.PTSDTSIndicatorOnlyPTS,
+		}}
+		h.OptionalHeader.PTS = &astits.ClockReference{Base: pkt.Pts()}
+		if pkt.Dts() != pkt.Pts() {
+			h.OptionalHeader.PTSDTSIndicator = astits.PTSDTSIndicatorBothPresent
+			h.OptionalHeader.DTS = &astits.ClockReference{Base: pkt.Dts()}
+		}
+
+		// Mux
+		if _, err = m.WriteData(&astits.MuxerData{
+			AdaptationField: &astits.PacketAdaptationField{RandomAccessIndicator: pkt.Flags()&avcodec.AV_PKT_FLAG_KEY > 0},
+			PES: &astits.PESData{
+				Data:   C.GoBytes(unsafe.Pointer(pkt.Data()), (C.int)(pkt.Size())),
+				Header: h,
+			},
+			PID: pid,
+		}); err != nil {
+			err = errors.Wrapf(err, "astilibav: muxing packet for pid %d failed", pid)
+			return
+		}
+		return
+	}
+	closeFn = func() (err error) {
+		if f == nil {
+			return
+		}
+		if err = f.Close(); err != nil {
+			err = errors.Wrapf(err, "astilibav: closing file %s failed", f.Name())
+			return
+		}
+		return
+	}
+	return
+}
+
+// NewTSPktDumper creates a new pkt dumper that multiplexes incoming packets into a real MPEG-TS file, keyed by
+// stream index, using the stream_type mapping provided in o
+func NewTSPktDumper(pattern string, o TSPktDumperOptions, data map[string]interface{}, e astiencoder.EmitEventFunc) (d *PktDumper, err error) {
+	fn, closeFn := PktDumpTS(o)
+	if d, err = NewPktDumper(pattern, fn, data, e); err != nil {
+		err = errors.Wrap(err, "astilibav: creating pkt dumper failed")
+		return
+	}
+	d.SetCloseFunc(closeFn)
+	return
+}
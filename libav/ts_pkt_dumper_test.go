@@ -0,0 +1,49 @@
+package astilibav
+
+import (
+	"testing"
+
+	"github.com/asticode/go-astits"
+	"github.com/asticode/goav/avcodec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTSPktDumperStreamStreamType(t *testing.T) {
+	for _, v := range []struct {
+		name    string
+		s       TSPktDumperStream
+		want    astits.StreamType
+		wantErr bool
+	}{
+		{
+			name: "explicit stream type wins over codec id",
+			s:    TSPktDumperStream{CodecID: avcodec.CODEC_ID_AAC, StreamType: astits.StreamTypeH264Video},
+			want: astits.StreamTypeH264Video,
+		},
+		{
+			name: "resolves h264 from codec id",
+			s:    TSPktDumperStream{CodecID: avcodec.CODEC_ID_H264},
+			want: astits.StreamTypeH264Video,
+		},
+		{
+			name: "resolves aac from codec id",
+			s:    TSPktDumperStream{CodecID: avcodec.CODEC_ID_AAC},
+			want: astits.StreamTypeAACAudio,
+		},
+		{
+			name:    "unknown codec id with no explicit stream type errors",
+			s:       TSPktDumperStream{CodecID: avcodec.CODEC_ID_NONE},
+			wantErr: true,
+		},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			st, err := v.s.streamType()
+			if v.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, v.want, st)
+		})
+	}
+}